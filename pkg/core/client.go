@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/HyNetwork/hysteria/pkg/transport/pktconns"
@@ -24,12 +27,60 @@ import (
 
 var ErrClosed = errors.New("closed")
 
+// ErrConnectionRejected is returned by DialTCP/DialUDP when the server
+// rejects the specific request (e.g. the target is unreachable or auth for
+// that request failed) — as opposed to a transport-level failure, the
+// underlying QUIC connection is still healthy and fine to reuse.
+type ErrConnectionRejected struct {
+	Message string
+}
+
+func (e *ErrConnectionRejected) Error() string {
+	return fmt.Sprintf("connection rejected: %s", e.Message)
+}
+
+// Congestion control algorithms selectable via NewClient's congestionControl
+// parameter. CongestionControlBrutal is the default and matches pre-existing
+// behavior; it's the only one pkg/congestion actually implements. BBR, Cubic
+// and New Reno are reserved names for a follow-up that adds real senders for
+// them to pkg/congestion — NewClient rejects these three up front rather than
+// pretending they work, see validateCongestionControl.
+const (
+	CongestionControlBrutal  = "brutal"
+	CongestionControlBBR     = "bbr"
+	CongestionControlCubic   = "cubic"
+	CongestionControlNewReno = "new_reno"
+)
+
+// UDP relay modes selectable via NewClient's udpRelayMode parameter.
+const (
+	// UDPRelayModeQUIC relays UDP packets as QUIC DATAGRAM frames, fragmenting
+	// payloads that don't fit in a single datagram. This is the original,
+	// default behavior.
+	UDPRelayModeQUIC = "quic"
+	// UDPRelayModeNative relays UDP packets as length-prefixed frames on the
+	// per-session stream instead, avoiding the ~1200-byte datagram limit and
+	// the fragmentation it requires.
+	UDPRelayModeNative = "native"
+)
+
+// maxUDPFragCount is the largest number of DATAGRAM fragments WriteTo will
+// split an oversized packet into before giving up on quic mode and falling
+// back to native mode for the rest of that UDP session's lifetime.
+const maxUDPFragCount = 4
+
 type Client struct {
 	serverAddr string
 	serverName string // QUIC SNI
 
-	sendBPS, recvBPS uint64
-	auth             []byte
+	sendBPS, recvBPS  uint64
+	auth              []byte
+	congestionControl string
+	udpRelayMode      string
+	paddingScheme     PaddingScheme
+
+	enableZeroRTT     bool
+	zeroRTTStatusFunc func(accepted bool)
 
 	tlsConfig  *tls.Config
 	quicConfig *quic.Config
@@ -48,8 +99,17 @@ type Client struct {
 	quicReconnectFunc func(err error)
 }
 
+// NewClient dials serverAddr and returns a ready-to-use Client.
+//
+// congestionControl selects the congestion controller; only
+// CongestionControlBrutal is actually implemented today (see
+// validateCongestionControl) — the other CongestionControl* names are
+// reserved for algorithms pkg/congestion doesn't ship yet, and NewClient
+// fails fast rather than silently running Brutal in their place.
 func NewClient(serverAddr string, auth []byte, tlsConfig *tls.Config, quicConfig *quic.Config,
-	pktConnFunc pktconns.ClientPacketConnFunc, sendBPS uint64, recvBPS uint64, quicReconnectFunc func(err error),
+	pktConnFunc pktconns.ClientPacketConnFunc, sendBPS uint64, recvBPS uint64, congestionControl string,
+	udpRelayMode string, paddingScheme PaddingScheme, enableZeroRTT bool, zeroRTTStatusFunc func(accepted bool),
+	quicReconnectFunc func(err error),
 ) (*Client, error) {
 	quicConfig.DisablePathMTUDiscovery = quicConfig.DisablePathMTUDiscovery || pmtud.DisablePathMTUDiscovery
 	// QUIC wants server name, but our serverAddr is usually host:port,
@@ -61,12 +121,34 @@ func NewClient(serverAddr string, auth []byte, tlsConfig *tls.Config, quicConfig
 		// Server name should be set in tlsConfig in that case.
 		serverName = ""
 	}
+	if congestionControl == "" {
+		congestionControl = CongestionControlBrutal
+	}
+	if err := validateCongestionControl(congestionControl); err != nil {
+		return nil, err
+	}
+	if udpRelayMode == "" {
+		udpRelayMode = UDPRelayModeQUIC
+	}
+	if paddingScheme == nil {
+		paddingScheme = NoPadding()
+	}
+	if enableZeroRTT && tlsConfig.ClientSessionCache == nil {
+		// 0-RTT needs a session cache to resume from; without one every
+		// connect would be a normal 1-RTT handshake regardless of the flag.
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
 	c := &Client{
 		serverAddr:        serverAddr,
 		serverName:        serverName,
 		sendBPS:           sendBPS,
 		recvBPS:           recvBPS,
 		auth:              auth,
+		congestionControl: congestionControl,
+		udpRelayMode:      udpRelayMode,
+		paddingScheme:     paddingScheme,
+		enableZeroRTT:     enableZeroRTT,
+		zeroRTTStatusFunc: zeroRTTStatusFunc,
 		tlsConfig:         tlsConfig,
 		quicConfig:        quicConfig,
 		pktConnFunc:       pktConnFunc,
@@ -91,13 +173,30 @@ func (c *Client) connect() error {
 	if err != nil {
 		return err
 	}
-	// Dial QUIC
-	quicConn, err := quic.Dial(pktConn, sAddr, c.serverName, c.tlsConfig, c.quicConfig)
+	// Dial QUIC, using 0-RTT/early data if enabled so the control stream (and
+	// the caller's first DialTCP/DialUDP) don't have to wait out a full
+	// handshake round trip on a resumed connection.
+	var quicConn quic.Connection
+	if c.enableZeroRTT {
+		var earlyConn quic.EarlyConnection
+		earlyConn, err = quic.DialEarly(pktConn, sAddr, c.serverName, c.tlsConfig, c.quicConfig)
+		quicConn = earlyConn
+	} else {
+		quicConn, err = quic.Dial(pktConn, sAddr, c.serverName, c.tlsConfig, c.quicConfig)
+	}
 	if err != nil {
 		_ = pktConn.Close()
 		return err
 	}
-	// Control stream
+	if c.enableZeroRTT && c.zeroRTTStatusFunc != nil {
+		go func() {
+			<-quicConn.HandshakeComplete().Done()
+			c.zeroRTTStatusFunc(quicConn.ConnectionState().TLS.Used0RTT)
+		}()
+	}
+	// Control stream. Everything written here is just auth (replay-safe);
+	// actual proxied payload writes are gated separately until the
+	// handshake is confirmed, see hyTCPConn.Write.
 	ctx, ctxCancel := context.WithTimeout(context.Background(), protocolTimeout)
 	stream, err := quicConn.OpenStreamSync(ctx)
 	ctxCancel()
@@ -142,6 +241,11 @@ func (c *Client) handleControlStream(qc quic.Connection, stream quic.Stream) (bo
 	if err != nil {
 		return false, "", err
 	}
+	// Append padding so the hello's on-wire length doesn't always match
+	// exactly; the server reads and discards it.
+	if err := writePadding(stream, c.paddingScheme); err != nil {
+		return false, "", err
+	}
 	// Receive server hello
 	var sh serverHello
 	err = struc.Unpack(stream, &sh)
@@ -155,6 +259,22 @@ func (c *Client) handleControlStream(qc quic.Connection, stream quic.Stream) (bo
 	return sh.OK, sh.Message, nil
 }
 
+// validateCongestionControl rejects congestion control names pkg/congestion
+// doesn't actually implement yet, instead of silently falling back to Brutal
+// or calling constructors that don't exist. Brutal is the only algorithm
+// pkg/congestion ships today; bbr/cubic/new_reno are reserved names for a
+// follow-up once pkg/congestion grows those senders.
+func validateCongestionControl(name string) error {
+	switch name {
+	case CongestionControlBrutal:
+		return nil
+	case CongestionControlBBR, CongestionControlCubic, CongestionControlNewReno:
+		return fmt.Errorf("congestion control %q is not implemented yet (pkg/congestion only provides Brutal)", name)
+	default:
+		return fmt.Errorf("unknown congestion control %q", name)
+	}
+}
+
 func (c *Client) handleMessage(qc quic.Connection) {
 	for {
 		msg, err := qc.ReceiveMessage()
@@ -230,6 +350,10 @@ func (c *Client) DialTCP(addr string) (net.Conn, error) {
 		_ = stream.Close()
 		return nil, err
 	}
+	if err := writePadding(stream, c.paddingScheme); err != nil {
+		_ = stream.Close()
+		return nil, err
+	}
 	// Read response
 	var sr serverResponse
 	err = struc.Unpack(stream, &sr)
@@ -239,13 +363,21 @@ func (c *Client) DialTCP(addr string) (net.Conn, error) {
 	}
 	if !sr.OK {
 		_ = stream.Close()
-		return nil, fmt.Errorf("connection rejected: %s", sr.Message)
+		return nil, &ErrConnectionRejected{Message: sr.Message}
 	}
-	return &hyTCPConn{
+	conn := &hyTCPConn{
 		Orig:             stream,
 		PseudoLocalAddr:  session.LocalAddr(),
 		PseudoRemoteAddr: session.RemoteAddr(),
-	}, nil
+	}
+	if c.enableZeroRTT {
+		// The request above may have gone out as 0-RTT/early data, which the
+		// server could still reject as a replay. Application payload isn't
+		// replay-safe, so make the first Write wait for the handshake (and
+		// therefore the 0-RTT decision) to be confirmed.
+		conn.handshakeDone = session.HandshakeComplete().Done()
+	}
+	return conn, nil
 }
 
 func (c *Client) DialUDP() (HyUDPConn, error) {
@@ -261,6 +393,10 @@ func (c *Client) DialUDP() (HyUDPConn, error) {
 		_ = stream.Close()
 		return nil, err
 	}
+	if err := writePadding(stream, c.paddingScheme); err != nil {
+		_ = stream.Close()
+		return nil, err
+	}
 	// Read response
 	var sr serverResponse
 	err = struc.Unpack(stream, &sr)
@@ -270,7 +406,7 @@ func (c *Client) DialUDP() (HyUDPConn, error) {
 	}
 	if !sr.OK {
 		_ = stream.Close()
-		return nil, fmt.Errorf("connection rejected: %s", sr.Message)
+		return nil, &ErrConnectionRejected{Message: sr.Message}
 	}
 
 	// Create a session in the map
@@ -283,9 +419,17 @@ func (c *Client) DialUDP() (HyUDPConn, error) {
 	sessionMap[sr.UDPSessionID] = nCh
 	c.udpSessionMutex.Unlock()
 
+	mode := c.udpRelayMode
+	if mode == UDPRelayModeQUIC && !session.ConnectionState().SupportsDatagrams {
+		// Server (or the negotiated QUIC transport params) doesn't support
+		// DATAGRAM frames; fall back to relaying over the stream instead.
+		mode = UDPRelayModeNative
+	}
+
 	pktConn := &hyUDPConn{
 		Session: session,
 		Stream:  stream,
+		Mode:    mode,
 		CloseFunc: func() {
 			c.udpSessionMutex.Lock()
 			if ch, ok := sessionMap[sr.UDPSessionID]; ok {
@@ -296,8 +440,22 @@ func (c *Client) DialUDP() (HyUDPConn, error) {
 		},
 		UDPSessionID: sr.UDPSessionID,
 		MsgCh:        nCh,
-	}
-	go pktConn.Hold()
+		nativeMsgCh:  nCh,
+	}
+	if c.enableZeroRTT {
+		// Same replay-safety concern as hyTCPConn: the request that opened
+		// this session may have gone out as 0-RTT, so the actual relayed UDP
+		// payload (not replay-safe) has to wait for handshake confirmation.
+		pktConn.handshakeDone = session.HandshakeComplete().Done()
+	}
+	// Always read with HoldNative, even when the session starts in quic mode:
+	// WriteTo can fall back to native mode mid-session (see nativeFallback),
+	// and the read side has to already be speaking native framing when that
+	// happens, since nothing re-launches or replaces this goroutine when it
+	// does. For a session that never falls back, the server never writes
+	// anything on this stream, so HoldNative just blocks on the frame-length
+	// prefix until the stream closes, and then closes the session.
+	go pktConn.HoldNative()
 	return pktConn, nil
 }
 
@@ -315,6 +473,11 @@ type hyTCPConn struct {
 	Orig             quic.Stream
 	PseudoLocalAddr  net.Addr
 	PseudoRemoteAddr net.Addr
+
+	// handshakeDone, if set, is waited on once before the first Write, to
+	// avoid sending replay-unsafe application data before 0-RTT is confirmed.
+	handshakeDone    <-chan struct{}
+	handshakeWaitOne sync.Once
 }
 
 func (w *hyTCPConn) Read(b []byte) (n int, err error) {
@@ -322,6 +485,9 @@ func (w *hyTCPConn) Read(b []byte) (n int, err error) {
 }
 
 func (w *hyTCPConn) Write(b []byte) (n int, err error) {
+	if w.handshakeDone != nil {
+		w.handshakeWaitOne.Do(func() { <-w.handshakeDone })
+	}
 	return w.Orig.Write(b)
 }
 
@@ -358,19 +524,37 @@ type HyUDPConn interface {
 type hyUDPConn struct {
 	Session      quic.Connection
 	Stream       quic.Stream
+	Mode         string
 	CloseFunc    func()
 	UDPSessionID uint32
 	MsgCh        <-chan *udpMessage
+
+	nativeMsgCh    chan *udpMessage // same underlying channel as MsgCh; kept bidirectional so HoldNative can feed it
+	nativeFallback uint32           // set with atomic; 1 once WriteTo has fallen back to native mode mid-session
+
+	// handshakeDone, if set, is waited on once before the first WriteTo, to
+	// avoid sending replay-unsafe UDP payload before 0-RTT is confirmed.
+	handshakeDone    <-chan struct{}
+	handshakeWaitOne sync.Once
 }
 
-func (c *hyUDPConn) Hold() {
-	// Hold the stream until it's closed
-	buf := make([]byte, 1024)
+// HoldNative reads length-prefixed udpMessage frames off the stream and feeds
+// them into MsgCh, mirroring what Client.handleMessage does for the
+// DATAGRAM-demuxed case, but scoped to this session's own stream. It's also
+// what detects the stream closing for sessions that never send a single
+// native frame (pure quic mode), since it's the only goroutine reading it.
+func (c *hyUDPConn) HoldNative() {
 	for {
-		_, err := c.Stream.Read(buf)
+		msg, err := readNativeUDPMessage(c.Stream)
 		if err != nil {
 			break
 		}
+		select {
+		case c.nativeMsgCh <- msg:
+			// OK
+		default:
+			// Silently drop the message when the channel is full
+		}
 	}
 	_ = c.Close()
 }
@@ -385,6 +569,9 @@ func (c *hyUDPConn) ReadFrom() ([]byte, string, error) {
 }
 
 func (c *hyUDPConn) WriteTo(p []byte, addr string) error {
+	if c.handshakeDone != nil {
+		c.handshakeWaitOne.Do(func() { <-c.handshakeDone })
+	}
 	host, port, err := utils.SplitHostPort(addr)
 	if err != nil {
 		return err
@@ -396,6 +583,9 @@ func (c *hyUDPConn) WriteTo(p []byte, addr string) error {
 		FragCount: 1,
 		Data:      p,
 	}
+	if c.Mode == UDPRelayModeNative || atomic.LoadUint32(&c.nativeFallback) == 1 {
+		return writeNativeUDPMessage(c.Stream, msg)
+	}
 	// try no frag first
 	var msgBuf bytes.Buffer
 	_ = struc.Pack(&msgBuf, &msg)
@@ -405,6 +595,12 @@ func (c *hyUDPConn) WriteTo(p []byte, addr string) error {
 			// need to frag
 			msg.MsgID = uint16(rand.Intn(0xFFFF)) + 1 // msgID must be > 0 when fragCount > 1
 			fragMsgs := fragUDPMessage(msg, int(errSize))
+			if len(fragMsgs) > maxUDPFragCount {
+				// Too fragmented to be worth it over DATAGRAM; fall back to
+				// the stream for this and all future writes on this session.
+				atomic.StoreUint32(&c.nativeFallback, 1)
+				return writeNativeUDPMessage(c.Stream, msg)
+			}
 			for _, fragMsg := range fragMsgs {
 				msgBuf.Reset()
 				_ = struc.Pack(&msgBuf, &fragMsg)
@@ -427,3 +623,37 @@ func (c *hyUDPConn) Close() error {
 	c.CloseFunc()
 	return c.Stream.Close()
 }
+
+// writeNativeUDPMessage writes msg to stream as a uint16-length-prefixed
+// struc-packed frame. Unlike the DATAGRAM path, the stream has no size limit,
+// so native mode never needs to fragment.
+func writeNativeUDPMessage(stream quic.Stream, msg udpMessage) error {
+	var msgBuf bytes.Buffer
+	if err := struc.Pack(&msgBuf, &msg); err != nil {
+		return err
+	}
+	var lenBuf [2]byte
+	binary.BigEndian.PutUint16(lenBuf[:], uint16(msgBuf.Len()))
+	if _, err := stream.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	_, err := stream.Write(msgBuf.Bytes())
+	return err
+}
+
+// readNativeUDPMessage reads one frame written by writeNativeUDPMessage.
+func readNativeUDPMessage(stream quic.Stream) (*udpMessage, error) {
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(stream, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	frame := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+	if _, err := io.ReadFull(stream, frame); err != nil {
+		return nil, err
+	}
+	var msg udpMessage
+	if err := struc.Unpack(bytes.NewReader(frame), &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
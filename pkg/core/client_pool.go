@@ -0,0 +1,308 @@
+package core
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HyNetwork/hysteria/pkg/transport/pktconns"
+	"github.com/lucas-clemente/quic-go"
+)
+
+// ErrPoolClosed is returned by ClientPool methods once Close has been called.
+var ErrPoolClosed = errors.New("client pool closed")
+
+// defaultIdleConnTTL is how long a pooled connection may sit with zero open
+// streams before the evictor reclaims it, once MinIdleConns is satisfied.
+const defaultIdleConnTTL = 2 * time.Minute
+
+// ClientPoolConfig carries everything NewClient needs to establish one
+// connection, plus the pool's own sizing knobs.
+type ClientPoolConfig struct {
+	ServerAddr        string
+	Auth              []byte
+	TLSConfig         *tls.Config
+	QUICConfig        *quic.Config
+	PktConnFunc       pktconns.ClientPacketConnFunc
+	SendBPS, RecvBPS  uint64
+	CongestionControl string
+	UDPRelayMode      string
+	PaddingScheme     PaddingScheme
+	EnableZeroRTT     bool
+	ZeroRTTStatusFunc func(accepted bool)
+
+	// MaxOpenStreams is the number of concurrently open TCP/UDP sessions a
+	// single QUIC connection is allowed to carry before the pool prefers
+	// routing new dials elsewhere and opens a fresh connection in the
+	// background.
+	MaxOpenStreams int32
+	// MinIdleConns is the number of connections the pool keeps warm even
+	// when idle, so a burst of dials doesn't have to pay connect latency.
+	MinIdleConns int
+	// MaxConns caps the total number of simultaneous QUIC connections.
+	MaxConns int
+}
+
+// poolConn is one QUIC connection (wrapped as a *Client) managed by a
+// ClientPool, along with the bookkeeping the pool needs to pick and evict it.
+type poolConn struct {
+	client      *Client
+	openStreams int32 // atomic
+	lastVisited int64 // atomic, unix nanoseconds
+	errored     int32 // atomic bool; set when a dial through this conn fails
+}
+
+func (pc *poolConn) touch() {
+	atomic.StoreInt64(&pc.lastVisited, time.Now().UnixNano())
+}
+
+func (pc *poolConn) idleSince() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&pc.lastVisited)))
+}
+
+// ClientPool maintains several parallel QUIC connections to the same server
+// and spreads DialTCP/DialUDP calls across them, so a burst of new streams
+// doesn't serialize behind one connection's OpenStream call or one
+// reconnect. It's a drop-in alternative to a single *Client for callers that
+// dial at high concurrency (e.g. a busy SOCKS5/HTTP proxy front end).
+type ClientPool struct {
+	cfg ClientPoolConfig
+
+	mu     sync.Mutex
+	conns  []*poolConn
+	rrNext int
+	closed bool
+
+	spawning int32 // atomic bool; true while a background connect is in flight
+}
+
+// NewClientPool dials MinIdleConns connections up front and returns a ready
+// pool. At least one connection must succeed, or NewClientPool fails the way
+// NewClient would.
+func NewClientPool(cfg ClientPoolConfig) (*ClientPool, error) {
+	if cfg.MinIdleConns < 1 {
+		cfg.MinIdleConns = 1
+	}
+	if cfg.MaxConns < cfg.MinIdleConns {
+		cfg.MaxConns = cfg.MinIdleConns
+	}
+	if cfg.MaxOpenStreams <= 0 {
+		cfg.MaxOpenStreams = 64
+	}
+	p := &ClientPool{cfg: cfg}
+	for i := 0; i < cfg.MinIdleConns; i++ {
+		pc, err := p.dialOne()
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			break
+		}
+		p.conns = append(p.conns, pc)
+	}
+	go p.evictLoop()
+	return p, nil
+}
+
+func (p *ClientPool) dialOne() (*poolConn, error) {
+	client, err := NewClient(p.cfg.ServerAddr, p.cfg.Auth, p.cfg.TLSConfig, p.cfg.QUICConfig,
+		p.cfg.PktConnFunc, p.cfg.SendBPS, p.cfg.RecvBPS, p.cfg.CongestionControl, p.cfg.UDPRelayMode,
+		p.cfg.PaddingScheme, p.cfg.EnableZeroRTT, p.cfg.ZeroRTTStatusFunc, func(err error) {})
+	if err != nil {
+		return nil, err
+	}
+	pc := &poolConn{client: client}
+	pc.touch()
+	return pc, nil
+}
+
+// pick returns the least-loaded connection, round-robining among ties, and
+// kicks off a background connect if every connection is at or past
+// MaxOpenStreams and the pool has room to grow.
+func (p *ClientPool) pick() (*poolConn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	if len(p.conns) == 0 {
+		p.mu.Unlock()
+		return nil, errors.New("client pool: no connections available")
+	}
+
+	var best *poolConn
+	var bestLoad int32
+	allBusy := true
+	for i := 0; i < len(p.conns); i++ {
+		c := p.conns[(p.rrNext+i)%len(p.conns)]
+		load := atomic.LoadInt32(&c.openStreams)
+		if load < p.cfg.MaxOpenStreams {
+			allBusy = false
+		}
+		if best == nil || load < bestLoad {
+			best, bestLoad = c, load
+		}
+	}
+	p.rrNext++
+	canGrow := len(p.conns) < p.cfg.MaxConns
+	p.mu.Unlock()
+
+	if allBusy && canGrow {
+		p.growInBackground()
+	}
+	return best, nil
+}
+
+// markErrored flags pc as unhealthy, unless err is just the server rejecting
+// one particular request (ErrConnectionRejected) — that's a fact about the
+// request, not the connection, and shouldn't cost every other stream sharing
+// pc its connection.
+func markErrored(pc *poolConn, err error) {
+	var rejected *ErrConnectionRejected
+	if errors.As(err, &rejected) {
+		return
+	}
+	atomic.StoreInt32(&pc.errored, 1)
+}
+
+// growInBackground opens one more connection and adds it to the pool, unless
+// a grow is already in flight or the pool is full/closed.
+func (p *ClientPool) growInBackground() {
+	if !atomic.CompareAndSwapInt32(&p.spawning, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&p.spawning, 0)
+		pc, err := p.dialOne()
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		if p.closed || len(p.conns) >= p.cfg.MaxConns {
+			p.mu.Unlock()
+			_ = pc.client.Close()
+			return
+		}
+		p.conns = append(p.conns, pc)
+		p.mu.Unlock()
+	}()
+}
+
+// evictLoop periodically removes idle or errored connections beyond
+// MinIdleConns, so a traffic spike doesn't leave the pool permanently
+// oversized.
+func (p *ClientPool) evictLoop() {
+	ticker := time.NewTicker(defaultIdleConnTTL / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		kept := p.conns[:0:0]
+		remaining := len(p.conns)
+		for _, pc := range p.conns {
+			// A conn with open streams is never evictable, errored or not —
+			// closing it out from under active TCP/UDP sessions would kill all
+			// of them just because one unrelated dial on the same conn failed.
+			evictable := atomic.LoadInt32(&pc.openStreams) == 0 &&
+				(atomic.LoadInt32(&pc.errored) != 0 || pc.idleSince() > defaultIdleConnTTL)
+			// Never evict below the MinIdleConns floor.
+			if evictable && remaining > p.cfg.MinIdleConns {
+				_ = pc.client.Close()
+				remaining--
+				continue
+			}
+			kept = append(kept, pc)
+		}
+		p.conns = kept
+		p.mu.Unlock()
+	}
+}
+
+// DialTCP dials through the least-loaded pooled connection.
+func (p *ClientPool) DialTCP(addr string) (net.Conn, error) {
+	pc, err := p.pick()
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt32(&pc.openStreams, 1)
+	pc.touch()
+	conn, err := pc.client.DialTCP(addr)
+	if err != nil {
+		atomic.AddInt32(&pc.openStreams, -1)
+		markErrored(pc, err)
+		return nil, err
+	}
+	return &pooledTCPConn{Conn: conn, pc: pc}, nil
+}
+
+// DialUDP dials through the least-loaded pooled connection.
+func (p *ClientPool) DialUDP() (HyUDPConn, error) {
+	pc, err := p.pick()
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt32(&pc.openStreams, 1)
+	pc.touch()
+	conn, err := pc.client.DialUDP()
+	if err != nil {
+		atomic.AddInt32(&pc.openStreams, -1)
+		markErrored(pc, err)
+		return nil, err
+	}
+	return &pooledUDPConn{HyUDPConn: conn, pc: pc}, nil
+}
+
+// Close closes every pooled connection.
+func (p *ClientPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	var firstErr error
+	for _, pc := range p.conns {
+		if err := pc.client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	p.conns = nil
+	return firstErr
+}
+
+// pooledTCPConn decrements its connection's open-stream count on Close, so
+// the pool's load accounting stays accurate.
+type pooledTCPConn struct {
+	net.Conn
+	pc       *poolConn
+	closeOne sync.Once
+}
+
+func (w *pooledTCPConn) Close() error {
+	w.closeOne.Do(func() {
+		atomic.AddInt32(&w.pc.openStreams, -1)
+		w.pc.touch()
+	})
+	return w.Conn.Close()
+}
+
+// pooledUDPConn mirrors pooledTCPConn for HyUDPConn.
+type pooledUDPConn struct {
+	HyUDPConn
+	pc       *poolConn
+	closeOne sync.Once
+}
+
+func (w *pooledUDPConn) Close() error {
+	w.closeOne.Do(func() {
+		atomic.AddInt32(&w.pc.openStreams, -1)
+		w.pc.touch()
+	})
+	return w.HyUDPConn.Close()
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// newTestPool builds a ClientPool with n fake, never-dialed poolConns so
+// pick()'s selection logic can be exercised without a real server.
+func newTestPool(n int, maxOpenStreams int32) *ClientPool {
+	p := &ClientPool{
+		cfg: ClientPoolConfig{
+			MaxOpenStreams: maxOpenStreams,
+			MaxConns:       n,
+		},
+	}
+	for i := 0; i < n; i++ {
+		p.conns = append(p.conns, &poolConn{})
+	}
+	return p
+}
+
+func TestClientPoolPickRoundRobinsIdleTies(t *testing.T) {
+	p := newTestPool(3, 64)
+
+	picked := make(map[*poolConn]int)
+	for i := 0; i < 6; i++ {
+		pc, err := p.pick()
+		if err != nil {
+			t.Fatalf("pick: %v", err)
+		}
+		picked[pc]++
+	}
+
+	for i, pc := range p.conns {
+		if picked[pc] != 2 {
+			t.Fatalf("conn %d picked %d times, want 2 (ties should round-robin evenly)", i, picked[pc])
+		}
+	}
+}
+
+func TestClientPoolPickPrefersLeastLoaded(t *testing.T) {
+	p := newTestPool(3, 64)
+	atomic.StoreInt32(&p.conns[0].openStreams, 5)
+	atomic.StoreInt32(&p.conns[1].openStreams, 1)
+	atomic.StoreInt32(&p.conns[2].openStreams, 9)
+
+	pc, err := p.pick()
+	if err != nil {
+		t.Fatalf("pick: %v", err)
+	}
+	if pc != p.conns[1] {
+		t.Fatalf("pick returned the %d-th conn, want the least-loaded one", indexOf(p.conns, pc))
+	}
+}
+
+func TestClientPoolPickReturnsErrorWhenEmpty(t *testing.T) {
+	p := newTestPool(0, 64)
+	if _, err := p.pick(); err == nil {
+		t.Fatal("expected an error picking from an empty pool")
+	}
+}
+
+func TestClientPoolPickReturnsErrorWhenClosed(t *testing.T) {
+	p := newTestPool(2, 64)
+	p.closed = true
+	if _, err := p.pick(); err != ErrPoolClosed {
+		t.Fatalf("pick on closed pool: got %v, want ErrPoolClosed", err)
+	}
+}
+
+func indexOf(conns []*poolConn, target *poolConn) int {
+	for i, c := range conns {
+		if c == target {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,319 @@
+package core
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/HyNetwork/hysteria/pkg/congestion"
+	"github.com/HyNetwork/hysteria/pkg/pmtud"
+	"github.com/HyNetwork/hysteria/pkg/transport/pktconns"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
+)
+
+// ClientV2 speaks the Hysteria 2 protocol: HTTP/3 for authentication, and a
+// single QUIC connection carrying length/varint-framed TCP streams and UDP
+// datagrams instead of the v1 struc-packed control stream. It is otherwise a
+// drop-in alternative to Client, so callers (socks5/http/redirect proxies)
+// can pick v1 or v2 at config time.
+type ClientV2 struct {
+	serverAddr string
+	serverName string
+
+	auth          []byte
+	sendBPS       uint64
+	paddingScheme PaddingScheme
+
+	tlsConfig  *tls.Config
+	quicConfig *quic.Config
+
+	pktConnFunc pktconns.ClientPacketConnFunc
+
+	pktConn    net.PacketConn
+	quicConn   quic.Connection
+	udpEnabled bool
+
+	closeMutex sync.Mutex
+	closed     bool
+
+	udpSessionMutex sync.RWMutex
+	udpSessionMap   map[uint32]chan *udpMessageV2
+	udpDefragger    defraggerV2
+}
+
+// NewClientV2 connects to a Hysteria 2 server and returns a ready-to-use ClientV2.
+func NewClientV2(serverAddr string, auth []byte, tlsConfig *tls.Config, quicConfig *quic.Config,
+	pktConnFunc pktconns.ClientPacketConnFunc, sendBPS uint64, paddingScheme PaddingScheme,
+) (*ClientV2, error) {
+	quicConfig.DisablePathMTUDiscovery = quicConfig.DisablePathMTUDiscovery || pmtud.DisablePathMTUDiscovery
+	serverName, _, err := net.SplitHostPort(serverAddr)
+	if err != nil {
+		serverName = ""
+	}
+	if paddingScheme == nil {
+		paddingScheme = NoPadding()
+	}
+	c := &ClientV2{
+		serverAddr:    serverAddr,
+		serverName:    serverName,
+		auth:          auth,
+		sendBPS:       sendBPS,
+		paddingScheme: paddingScheme,
+		tlsConfig:     tlsConfig,
+		quicConfig:    quicConfig,
+		pktConnFunc:   pktConnFunc,
+	}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *ClientV2) connect() error {
+	pktConn, sAddr, err := c.pktConnFunc(c.serverAddr)
+	if err != nil {
+		return err
+	}
+	// Dial the QUIC connection ourselves (as early data, since http3 requires
+	// an EarlyConnection) rather than letting http3.RoundTripper dial its own
+	// separately-pooled connection — the auth request and the proxied
+	// TCP/UDP streams must land on the same connection, or the server has no
+	// way to associate them.
+	quicConn, err := quic.DialEarly(pktConn, sAddr, c.serverName, c.tlsConfig, c.quicConfig)
+	if err != nil {
+		_ = pktConn.Close()
+		return err
+	}
+	udpEnabled, err := c.authenticate(quicConn)
+	if err != nil {
+		_ = qErrorAuth.Send(quicConn)
+		_ = pktConn.Close()
+		return err
+	}
+	c.udpSessionMap = make(map[uint32]chan *udpMessageV2)
+	if udpEnabled {
+		go c.handleMessage(quicConn)
+	}
+	c.pktConn = pktConn
+	c.quicConn = quicConn
+	c.udpEnabled = udpEnabled
+	return nil
+}
+
+// authenticate performs the v2 HTTP/3 auth handshake over qc, the same QUIC
+// connection DialTCP/DialUDP will later open proxy streams on, and sets up
+// Brutal congestion control at the negotiated receive rate. The
+// RoundTripper's Dial is pinned to qc so it never opens a connection of its
+// own to do this.
+func (c *ClientV2) authenticate(qc quic.EarlyConnection) (udpEnabled bool, err error) {
+	// tr.Close would tear down whatever connection Dial returned, but Dial is
+	// pinned to qc, which is owned by ClientV2 (connect assigns it to
+	// c.quicConn right after this returns) — closing it here would kill the
+	// connection before a single proxy stream is ever opened on it. tr itself
+	// is cheap and single-use, so just let it get GC'd instead of closing it.
+	tr := &http3.RoundTripper{
+		TLSClientConfig: c.tlsConfig,
+		QuicConfig:      c.quicConfig,
+		Dial: func(_ string, _ string, _ *tls.Config, _ *quic.Config) (quic.EarlyConnection, error) {
+			return qc, nil
+		},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "https://"+c.serverAddr+authRequestPath, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set(headerAuth, base64.StdEncoding.EncodeToString(c.auth))
+	req.Header.Set(headerCCRX, strconv.FormatUint(c.sendBPS, 10))
+	if pad := c.paddingScheme(); len(pad) > 0 {
+		// Perturbs the auth request's header block size so it doesn't always
+		// land on the same on-wire length.
+		req.Header.Set(headerPadding, base64.StdEncoding.EncodeToString(pad))
+	}
+
+	resp, err := tr.RoundTripOpt(req, http3.RoundTripOpt{OnlyCachedConn: false})
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("auth error: server returned status %d", resp.StatusCode)
+	}
+
+	recvBPS, _ := strconv.ParseUint(resp.Header.Get(headerCCRX), 10, 64)
+	if recvBPS > 0 {
+		qc.SetCongestionControl(congestion.NewBrutalSender(recvBPS))
+	}
+	return resp.Header.Get(headerUDP) == "true", nil
+}
+
+func (c *ClientV2) handleMessage(qc quic.Connection) {
+	for {
+		msg, err := qc.ReceiveMessage()
+		if err != nil {
+			return
+		}
+		udpMsg, err := unpackUDPMessageV2(msg)
+		if err != nil {
+			continue
+		}
+		dfMsg := c.udpDefragger.Feed(udpMsg)
+		if dfMsg == nil {
+			continue
+		}
+		c.udpSessionMutex.RLock()
+		ch, ok := c.udpSessionMap[dfMsg.SessionID]
+		if ok {
+			select {
+			case ch <- dfMsg:
+			default:
+				// Silently drop the message when the channel is full
+			}
+		}
+		c.udpSessionMutex.RUnlock()
+	}
+}
+
+// DialTCP opens a new stream and issues a Hysteria 2 TCP request frame for addr.
+func (c *ClientV2) DialTCP(addr string) (net.Conn, error) {
+	stream, err := c.openStream()
+	if err != nil {
+		return nil, err
+	}
+	req := &tcpRequestV2{Addr: addr, Padding: c.paddingScheme()}
+	if err := req.WriteTo(stream); err != nil {
+		_ = stream.Close()
+		return nil, err
+	}
+	resp, err := readTCPResponseV2(stream)
+	if err != nil {
+		_ = stream.Close()
+		return nil, err
+	}
+	if !resp.OK {
+		_ = stream.Close()
+		return nil, &ErrConnectionRejected{Message: resp.Message}
+	}
+	return &hyTCPConn{
+		Orig:             stream,
+		PseudoLocalAddr:  c.quicConn.LocalAddr(),
+		PseudoRemoteAddr: c.quicConn.RemoteAddr(),
+	}, nil
+}
+
+// DialUDP registers a new UDP session and returns a HyUDPConn backed by v2's
+// single datagram format.
+func (c *ClientV2) DialUDP() (HyUDPConn, error) {
+	if !c.udpEnabled {
+		return nil, fmt.Errorf("server does not support UDP relaying")
+	}
+	sessionID := rand.Uint32()
+	c.udpSessionMutex.Lock()
+	nCh := make(chan *udpMessageV2, 1024)
+	sessionMap := c.udpSessionMap
+	sessionMap[sessionID] = nCh
+	c.udpSessionMutex.Unlock()
+
+	return &hyUDPConnV2{
+		Session:   c.quicConn,
+		SessionID: sessionID,
+		MsgCh:     nCh,
+		CloseFunc: func() {
+			c.udpSessionMutex.Lock()
+			if ch, ok := sessionMap[sessionID]; ok {
+				close(ch)
+				delete(sessionMap, sessionID)
+			}
+			c.udpSessionMutex.Unlock()
+		},
+	}, nil
+}
+
+func (c *ClientV2) openStream() (quic.Stream, error) {
+	c.closeMutex.Lock()
+	defer c.closeMutex.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	return c.quicConn.OpenStream()
+}
+
+func (c *ClientV2) Close() error {
+	c.closeMutex.Lock()
+	defer c.closeMutex.Unlock()
+	err := qErrorGeneric.Send(c.quicConn)
+	_ = c.pktConn.Close()
+	c.closed = true
+	return err
+}
+
+// hyUDPConnV2 implements HyUDPConn on top of v2-style QUIC datagrams: one per
+// packet, or up to maxUDPFragCountV2 fragments for a packet too large for a
+// single datagram (v1's hyUDPConn additionally held a stream open; v2 has no
+// such stream since the session is carried entirely in the datagram header).
+type hyUDPConnV2 struct {
+	Session   quic.Connection
+	SessionID uint32
+	MsgCh     <-chan *udpMessageV2
+	CloseFunc func()
+
+	packetIDMutex sync.Mutex
+	nextPacketID  uint16
+}
+
+func (c *hyUDPConnV2) ReadFrom() ([]byte, string, error) {
+	msg := <-c.MsgCh
+	if msg == nil {
+		return nil, "", ErrClosed
+	}
+	return msg.Data, msg.Addr, nil
+}
+
+func (c *hyUDPConnV2) WriteTo(p []byte, addr string) error {
+	c.packetIDMutex.Lock()
+	c.nextPacketID++
+	packetID := c.nextPacketID
+	c.packetIDMutex.Unlock()
+
+	msg := udpMessageV2{
+		SessionID: c.SessionID,
+		PacketID:  packetID,
+		FragID:    0,
+		FragCount: 1,
+		Addr:      addr,
+		Data:      p,
+	}
+	err := c.Session.SendMessage(msg.Pack())
+	if err == nil {
+		return nil
+	}
+	errSize, ok := err.(quic.ErrMessageToLarge)
+	if !ok {
+		return err
+	}
+	// p doesn't fit in a single DATAGRAM; split it across up to
+	// maxUDPFragCountV2 fragments (see fragUDPMessageV2). Unlike v1's
+	// hyUDPConn, there's no stream to fall back to here, so a packet that
+	// still doesn't fit after fragmenting is a hard failure.
+	frags := fragUDPMessageV2(msg, int(errSize))
+	if len(frags) == 0 || len(frags) > maxUDPFragCountV2 {
+		return fmt.Errorf("udp packet too large to relay even fragmented into %d datagrams", maxUDPFragCountV2)
+	}
+	for _, frag := range frags {
+		if err := c.Session.SendMessage(frag.Pack()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *hyUDPConnV2) Close() error {
+	c.CloseFunc()
+	return nil
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestWritePaddingLengthPrefix(t *testing.T) {
+	cases := []PaddingScheme{
+		NoPadding(),
+		RangePadding(4, 4),
+		func() []byte { return make([]byte, 300) },
+	}
+	for _, scheme := range cases {
+		var buf bytes.Buffer
+		if err := writePadding(&buf, scheme); err != nil {
+			t.Fatalf("writePadding: %v", err)
+		}
+		if buf.Len() < 2 {
+			t.Fatalf("frame too short: %d bytes", buf.Len())
+		}
+		declared := binary.BigEndian.Uint16(buf.Bytes()[:2])
+		if got := uint16(buf.Len() - 2); got != declared {
+			t.Fatalf("length prefix %d doesn't match actual padding length %d", declared, got)
+		}
+	}
+}
+
+func TestWritePaddingTruncatesOversizedScheme(t *testing.T) {
+	oversized := func() []byte { return make([]byte, maxPaddingLen+1000) }
+	var buf bytes.Buffer
+	if err := writePadding(&buf, oversized); err != nil {
+		t.Fatalf("writePadding: %v", err)
+	}
+	declared := binary.BigEndian.Uint16(buf.Bytes()[:2])
+	if got := uint16(buf.Len() - 2); got != declared {
+		t.Fatalf("length prefix %d doesn't match actual padding length %d (desync)", declared, got)
+	}
+	if int(declared) > maxPaddingLen {
+		t.Fatalf("declared length %d exceeds maxPaddingLen %d", declared, maxPaddingLen)
+	}
+}
+
+func TestRangePaddingClampsToMaxPaddingLen(t *testing.T) {
+	scheme := RangePadding(0, maxPaddingLen+50000)
+	for i := 0; i < 20; i++ {
+		if n := len(scheme()); n > maxPaddingLen {
+			t.Fatalf("RangePadding produced %d bytes, want <= %d", n, maxPaddingLen)
+		}
+	}
+}
+
+func TestParsePaddingSchemeRejectsOutOfRangeBounds(t *testing.T) {
+	if _, err := ParsePaddingScheme("range:0-100000"); err == nil {
+		t.Fatal("expected error for upper bound beyond maxPaddingLen")
+	}
+}
+
+func TestParsePaddingSchemeNone(t *testing.T) {
+	scheme, err := ParsePaddingScheme("none")
+	if err != nil {
+		t.Fatalf("ParsePaddingScheme: %v", err)
+	}
+	if pad := scheme(); len(pad) != 0 {
+		t.Fatalf("expected no padding, got %d bytes", len(pad))
+	}
+}
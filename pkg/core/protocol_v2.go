@@ -0,0 +1,268 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Hysteria 2 wire format.
+//
+// Unlike v1, which relies on struc-packed fixed-layout messages on a
+// dedicated control stream, v2 authenticates over HTTP/3 and multiplexes
+// everything else (TCP proxying and UDP datagrams) directly on top of the
+// QUIC connection, using varint-prefixed fields similar to QUIC's own wire
+// format.
+
+const (
+	// authRequestPath is the well-known path the v2 client POSTs to in order
+	// to authenticate and negotiate rates before opening any data streams.
+	authRequestPath = "/auth"
+
+	// headerAuth carries the client's auth payload (base64) on the auth request.
+	headerAuth = "Hysteria-Auth"
+	// headerCCRX carries the advertised/negotiated receive rate, in bytes per second.
+	headerCCRX = "Hysteria-CC-RX"
+	// headerUDP tells the client, via the auth response, whether UDP relaying is supported.
+	headerUDP = "Hysteria-UDP"
+	// headerPadding carries arbitrary padding to perturb the on-wire size of the
+	// (otherwise highly regular) auth request/response.
+	headerPadding = "Hysteria-Padding"
+
+	// frameTypeTCPRequest is the varint frame type sent as the first bytes of a
+	// new bidirectional stream to request a TCP proxy connection.
+	frameTypeTCPRequest = 0x401
+)
+
+// tcpRequestV2 is the frame a v2 client sends as the first bytes on a newly
+// opened stream to request a TCP proxy connection to Addr.
+//
+//	frameTypeTCPRequest varint | addrLen varint | addr | paddingLen varint | padding
+type tcpRequestV2 struct {
+	Addr    string
+	Padding []byte
+}
+
+func (r *tcpRequestV2) WriteTo(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	writeUvarint(bw, frameTypeTCPRequest)
+	writeUvarint(bw, uint64(len(r.Addr)))
+	_, _ = bw.WriteString(r.Addr)
+	writeUvarint(bw, uint64(len(r.Padding)))
+	_, _ = bw.Write(r.Padding)
+	return bw.Flush()
+}
+
+// tcpResponseV2 is the frame a v2 server replies with on the same stream
+// after a tcpRequestV2.
+//
+//	ok byte | msgLen varint | msg
+type tcpResponseV2 struct {
+	OK      bool
+	Message string
+}
+
+// maxTCPResponseMsgLen bounds tcpResponseV2.Message: it's only ever a short
+// human-readable rejection reason, so there's no legitimate reason for a
+// conforming server to send more than this, and without a cap a malicious or
+// buggy server could make readTCPResponseV2 allocate an arbitrary amount of
+// memory off a single varint.
+const maxTCPResponseMsgLen = 4096
+
+func readTCPResponseV2(r io.Reader) (*tcpResponseV2, error) {
+	br := toByteReader(r)
+	okByte, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	msgLen, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if msgLen > maxTCPResponseMsgLen {
+		return nil, fmt.Errorf("tcp response v2: message length %d exceeds limit of %d", msgLen, maxTCPResponseMsgLen)
+	}
+	msg := make([]byte, msgLen)
+	if _, err := io.ReadFull(br, msg); err != nil {
+		return nil, err
+	}
+	return &tcpResponseV2{OK: okByte != 0, Message: string(msg)}, nil
+}
+
+// udpMessageV2 is the single datagram format used for all v2 UDP relaying,
+// sent either as a QUIC DATAGRAM frame or, when fragmented, split across
+// several of them.
+//
+//	sessionID uint32 | packetID uint16 | fragID uint8 | fragCount uint8 | addrLen varint | addr | payload
+type udpMessageV2 struct {
+	SessionID uint32
+	PacketID  uint16
+	FragID    uint8
+	FragCount uint8
+	Addr      string
+	Data      []byte
+}
+
+func (m *udpMessageV2) Pack() []byte {
+	var buf []byte
+	buf = append(buf, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buf, m.SessionID)
+	var hdr [2]byte
+	binary.BigEndian.PutUint16(hdr[:], m.PacketID)
+	buf = append(buf, hdr[:]...)
+	buf = append(buf, m.FragID, m.FragCount)
+	buf = appendUvarint(buf, uint64(len(m.Addr)))
+	buf = append(buf, m.Addr...)
+	buf = append(buf, m.Data...)
+	return buf
+}
+
+func unpackUDPMessageV2(b []byte) (*udpMessageV2, error) {
+	if len(b) < 8 {
+		return nil, errors.New("udp message v2: too short")
+	}
+	m := &udpMessageV2{
+		SessionID: binary.BigEndian.Uint32(b[0:4]),
+		PacketID:  binary.BigEndian.Uint16(b[4:6]),
+		FragID:    b[6],
+		FragCount: b[7],
+	}
+	rest := b[8:]
+	addrLen, n := binary.Uvarint(rest)
+	if n <= 0 || uint64(len(rest)-n) < addrLen {
+		return nil, errors.New("udp message v2: malformed address")
+	}
+	rest = rest[n:]
+	m.Addr = string(rest[:addrLen])
+	m.Data = rest[addrLen:]
+	return m, nil
+}
+
+// maxUDPFragCountV2 is the largest number of DATAGRAM fragments WriteTo will
+// split an oversized UDP payload into, mirroring v1's maxUDPFragCount. v2 has
+// no stream to fall back to the way v1's hyUDPConn does, so a payload that
+// still doesn't fit after this many fragments is a hard failure.
+const maxUDPFragCountV2 = 4
+
+// fragUDPMessageV2 splits msg's Data across as many udpMessageV2 fragments as
+// needed to fit each one's packed size within maxSize, preserving
+// SessionID/PacketID/Addr on every fragment so any subset is independently
+// decodable by unpackUDPMessageV2. Returns nil if maxSize can't even fit the
+// header.
+func fragUDPMessageV2(msg udpMessageV2, maxSize int) []udpMessageV2 {
+	headerLen := 8 + uvarintLen(uint64(len(msg.Addr))) + len(msg.Addr)
+	maxPayload := maxSize - headerLen
+	if maxPayload <= 0 {
+		return nil
+	}
+	var frags []udpMessageV2
+	data := msg.Data
+	for {
+		n := maxPayload
+		if n > len(data) {
+			n = len(data)
+		}
+		frags = append(frags, udpMessageV2{
+			SessionID: msg.SessionID,
+			PacketID:  msg.PacketID,
+			FragID:    uint8(len(frags)),
+			Addr:      msg.Addr,
+			Data:      data[:n],
+		})
+		data = data[n:]
+		if len(data) == 0 {
+			break
+		}
+	}
+	for i := range frags {
+		frags[i].FragCount = uint8(len(frags))
+	}
+	return frags
+}
+
+func uvarintLen(v uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], v)
+}
+
+// defraggerV2 reassembles udpMessageV2 fragments keyed by (SessionID,
+// PacketID), mirroring the role v1's defragger plays for the struc-packed
+// udpMessage format.
+type defraggerV2 struct {
+	mu    sync.Mutex
+	parts map[uint64]*udpFragStateV2
+}
+
+type udpFragStateV2 struct {
+	first    *udpMessageV2
+	frags    [][]byte
+	received int
+}
+
+// Feed adds msg to the in-progress reassembly for its (SessionID, PacketID)
+// and returns the fully reassembled message once every fragment has arrived.
+// It returns nil while fragments are still outstanding, and returns msg
+// itself unchanged when it isn't fragmented at all.
+func (d *defraggerV2) Feed(msg *udpMessageV2) *udpMessageV2 {
+	if msg.FragCount <= 1 {
+		return msg
+	}
+	if msg.FragID >= msg.FragCount {
+		return nil
+	}
+	key := uint64(msg.SessionID)<<16 | uint64(msg.PacketID)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.parts == nil {
+		d.parts = make(map[uint64]*udpFragStateV2)
+	}
+	st, ok := d.parts[key]
+	if !ok {
+		st = &udpFragStateV2{first: msg, frags: make([][]byte, msg.FragCount)}
+		d.parts[key] = st
+	}
+	if st.frags[msg.FragID] == nil {
+		st.frags[msg.FragID] = msg.Data
+		st.received++
+	}
+	if st.received < len(st.frags) {
+		return nil
+	}
+	delete(d.parts, key)
+
+	full := make([]byte, 0, len(st.frags)*len(st.frags[0]))
+	for _, f := range st.frags {
+		full = append(full, f...)
+	}
+	out := *st.first
+	out.FragID = 0
+	out.FragCount = 1
+	out.Data = full
+	return &out
+}
+
+func writeUvarint(w io.ByteWriter, v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	for i := 0; i < n; i++ {
+		_ = w.WriteByte(buf[i])
+	}
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+// toByteReader adapts an io.Reader to an io.ByteReader, which binary.ReadUvarint requires.
+func toByteReader(r io.Reader) io.ByteReader {
+	if br, ok := r.(io.ByteReader); ok {
+		return br
+	}
+	return bufio.NewReader(r)
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestTCPRequestV2RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	req := &tcpRequestV2{Addr: "example.com:443", Padding: []byte("pad")}
+	if err := req.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+
+	br := toByteReader(&buf)
+	frameType, err := binary.ReadUvarint(br)
+	if err != nil || frameType != frameTypeTCPRequest {
+		t.Fatalf("frame type = %d, %v; want %d", frameType, err, frameTypeTCPRequest)
+	}
+	addrLen, err := binary.ReadUvarint(br)
+	if err != nil {
+		t.Fatalf("read addrLen: %v", err)
+	}
+	addr := make([]byte, addrLen)
+	for i := range addr {
+		b, err := br.ReadByte()
+		if err != nil {
+			t.Fatalf("read addr byte %d: %v", i, err)
+		}
+		addr[i] = b
+	}
+	if string(addr) != req.Addr {
+		t.Fatalf("addr = %q, want %q", addr, req.Addr)
+	}
+}
+
+func TestTCPResponseV2RoundTrip(t *testing.T) {
+	cases := []*tcpResponseV2{
+		{OK: true, Message: ""},
+		{OK: false, Message: "connection refused"},
+	}
+	for _, resp := range cases {
+		var buf bytes.Buffer
+		okByte := byte(0)
+		if resp.OK {
+			okByte = 1
+		}
+		buf.WriteByte(okByte)
+		buf.Write(appendUvarint(nil, uint64(len(resp.Message))))
+		buf.WriteString(resp.Message)
+
+		got, err := readTCPResponseV2(&buf)
+		if err != nil {
+			t.Fatalf("readTCPResponseV2: %v", err)
+		}
+		if got.OK != resp.OK || got.Message != resp.Message {
+			t.Fatalf("got %+v, want %+v", got, resp)
+		}
+	}
+}
+
+func TestReadTCPResponseV2RejectsOversizedMessage(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteByte(0)
+	buf.Write(appendUvarint(nil, maxTCPResponseMsgLen+1))
+	if _, err := readTCPResponseV2(&buf); err == nil {
+		t.Fatal("expected an error for a message length beyond maxTCPResponseMsgLen")
+	}
+}
+
+func TestUDPMessageV2PackUnpackRoundTrip(t *testing.T) {
+	msg := &udpMessageV2{
+		SessionID: 0xDEADBEEF,
+		PacketID:  1234,
+		FragID:    0,
+		FragCount: 1,
+		Addr:      "1.2.3.4:5678",
+		Data:      []byte("hello world"),
+	}
+	got, err := unpackUDPMessageV2(msg.Pack())
+	if err != nil {
+		t.Fatalf("unpackUDPMessageV2: %v", err)
+	}
+	if got.SessionID != msg.SessionID || got.PacketID != msg.PacketID ||
+		got.FragID != msg.FragID || got.FragCount != msg.FragCount ||
+		got.Addr != msg.Addr || !bytes.Equal(got.Data, msg.Data) {
+		t.Fatalf("got %+v, want %+v", got, msg)
+	}
+}
+
+func TestFragUDPMessageV2AndDefraggerRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("x"), 300)
+	msg := udpMessageV2{
+		SessionID: 42,
+		PacketID:  7,
+		Addr:      "10.0.0.1:53",
+		Data:      payload,
+	}
+
+	frags := fragUDPMessageV2(msg, 100)
+	if len(frags) < 2 {
+		t.Fatalf("expected multiple fragments, got %d", len(frags))
+	}
+
+	var d defraggerV2
+	var reassembled *udpMessageV2
+	for i, frag := range frags {
+		packed := frag.Pack()
+		unpacked, err := unpackUDPMessageV2(packed)
+		if err != nil {
+			t.Fatalf("unpack fragment %d: %v", i, err)
+		}
+		if out := d.Feed(unpacked); out != nil {
+			reassembled = out
+		}
+	}
+	if reassembled == nil {
+		t.Fatal("defragger never produced a reassembled message")
+	}
+	if reassembled.SessionID != msg.SessionID || reassembled.Addr != msg.Addr {
+		t.Fatalf("reassembled metadata mismatch: %+v", reassembled)
+	}
+	if !bytes.Equal(reassembled.Data, payload) {
+		t.Fatalf("reassembled data mismatch: got %d bytes, want %d", len(reassembled.Data), len(payload))
+	}
+}
+
+func TestDefraggerV2ReturnsUnfragmentedMessageUnchanged(t *testing.T) {
+	var d defraggerV2
+	msg := &udpMessageV2{SessionID: 1, PacketID: 2, FragID: 0, FragCount: 1, Data: []byte("hi")}
+	if out := d.Feed(msg); out != msg {
+		t.Fatalf("expected Feed to pass through an unfragmented message unchanged")
+	}
+}